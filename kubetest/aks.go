@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var (
+	aksResourceGroup = flag.String("aks-resource-group", "", "Azure resource group to create the AKS cluster in")
+	aksClusterName   = flag.String("aks-cluster", "", "Name of the AKS cluster to create or reuse")
+	aksLocation      = flag.String("aks-location", "eastus", "Azure location to create the AKS cluster in")
+	aksNodeCount     = flag.Int("aks-node-count", 3, "Number of nodes in the default node pool")
+)
+
+// azureServicePrincipal is the JSON shape of AZURE_CREDENTIALS, matching
+// the convention `az ad sp create-for-rbac --sdk-auth` emits.
+type azureServicePrincipal struct {
+	ClientID       string `json:"clientId"`
+	ClientSecret   string `json:"clientSecret"`
+	TenantID       string `json:"tenantId"`
+	SubscriptionID string `json:"subscriptionId"`
+}
+
+// aks deploys a cluster via the `az aks` CLI, authenticating with a
+// service principal described by the AZURE_CREDENTIALS env var.
+type aks struct {
+	resourceGroup string
+	cluster       string
+	location      string
+	nodeCount     int
+}
+
+// NewAKS validates aks-specific flags/credentials, logs the az CLI in as
+// the AZURE_CREDENTIALS service principal, and returns a deployer that
+// drives `az aks` against Azure.
+func NewAKS() (deployer, error) {
+	if *aksClusterName == "" {
+		return nil, fmt.Errorf("--aks-cluster must be set for --deployment=aks")
+	}
+	if *aksResourceGroup == "" {
+		return nil, fmt.Errorf("--aks-resource-group must be set for --deployment=aks")
+	}
+	if err := azLoginServicePrincipal(); err != nil {
+		return nil, err
+	}
+	return &aks{
+		resourceGroup: *aksResourceGroup,
+		cluster:       *aksClusterName,
+		location:      *aksLocation,
+		nodeCount:     *aksNodeCount,
+	}, nil
+}
+
+// azLoginServicePrincipal parses the AZURE_CREDENTIALS service principal
+// and runs `az login --service-principal` with it, so every subsequent
+// `az aks ...` call in this package is actually authenticated instead of
+// relying on whatever (if anything) is already logged in.
+func azLoginServicePrincipal() error {
+	raw := os.Getenv("AZURE_CREDENTIALS")
+	if raw == "" {
+		return fmt.Errorf("--deployment=aks requires AZURE_CREDENTIALS to be set")
+	}
+	var sp azureServicePrincipal
+	if err := json.Unmarshal([]byte(raw), &sp); err != nil {
+		return fmt.Errorf("AZURE_CREDENTIALS is not valid service-principal JSON: %v", err)
+	}
+	if sp.ClientID == "" || sp.ClientSecret == "" || sp.TenantID == "" {
+		return fmt.Errorf("AZURE_CREDENTIALS must set clientId, clientSecret and tenantId")
+	}
+	if err := finishRunning(exec.Command("az", "login", "--service-principal",
+		"-u", sp.ClientID, "-p", sp.ClientSecret, "--tenant", sp.TenantID)); err != nil {
+		return fmt.Errorf("az login --service-principal failed: %v", err)
+	}
+	if sp.SubscriptionID != "" {
+		if err := finishRunning(exec.Command("az", "account", "set", "--subscription", sp.SubscriptionID)); err != nil {
+			return fmt.Errorf("az account set --subscription failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (a *aks) Up() error {
+	return finishRunning(exec.Command("az", "aks", "create",
+		"--resource-group", a.resourceGroup,
+		"--name", a.cluster,
+		"--location", a.location,
+		"--node-count", fmt.Sprintf("%d", a.nodeCount),
+		"--generate-ssh-keys"))
+}
+
+func (a *aks) IsUp() error {
+	return finishRunning(exec.Command("az", "aks", "show", "--resource-group", a.resourceGroup, "--name", a.cluster))
+}
+
+func (a *aks) SetupKubecfg() error {
+	return finishRunning(exec.Command("az", "aks", "get-credentials", "--resource-group", a.resourceGroup, "--name", a.cluster, "--overwrite-existing"))
+}
+
+func (a *aks) Down() error {
+	return finishRunning(exec.Command("az", "aks", "delete", "--resource-group", a.resourceGroup, "--name", a.cluster, "--yes"))
+}
+
+// Metadata satisfies DeployerMetadataProvider, reporting the AKS
+// cluster/location/node-count this run requested.
+func (a *aks) Metadata() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"cloud-provider": "azure",
+		"region":         a.location,
+		"node-count":     a.nodeCount,
+		"cluster":        a.cluster,
+	}, nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+)
+
+// conformanceProfiles are curated focus/skip pairs for --conformance-profile,
+// saving callers from having to know the right regexes for common slices of
+// the conformance suite.
+var conformanceProfiles = map[string]struct{ focus, skip string }{
+	"fast":    {focus: `\[Conformance\]`, skip: `\[Slow\]|\[Serial\]`},
+	"full":    {focus: `\[Conformance\]`, skip: ``},
+	"network": {focus: `\[Conformance\].*\[sig-network\]`, skip: `\[Slow\]`},
+	"storage": {focus: `\[Conformance\].*\[sig-storage\]`, skip: `\[Slow\]`},
+}
+
+// runConformance drives e2e.test directly with Ginkgo flags instead of
+// going through the --test_args string, so kubetest can reason about
+// parallelism, focus/skip and retries structurally. It owns the cluster
+// lifecycle itself (--up/--down), the same way run() does for the
+// --test_args path, since this replaces run() rather than running
+// alongside it.
+func runConformance(deploy deployer, o *options) error {
+	if o.up {
+		if err := xmlWrapSuite("conformance", "up", deploy.Up); err != nil {
+			return err
+		}
+		if err := xmlWrapSuite("conformance", "setup-kubecfg", deploy.SetupKubecfg); err != nil {
+			return err
+		}
+	}
+
+	testErr := runConformanceTests(o)
+
+	if o.down {
+		downErr := xmlWrapSuite("conformance", "down", deploy.Down)
+		if testErr == nil {
+			testErr = downErr
+		}
+	}
+	return testErr
+}
+
+// runConformanceTests runs the Ginkgo conformance suite (plus any
+// --conformance-retry-failed reruns) against whatever cluster is already
+// up, merging Ginkgo's per-spec JUnit output into the "conformance" suite.
+func runConformanceTests(o *options) error {
+	focus, skip := o.conformanceFocus, o.conformanceSkip
+	if o.conformanceProfile != "" {
+		profile, ok := conformanceProfiles[o.conformanceProfile]
+		if !ok {
+			return fmt.Errorf("unknown --conformance-profile %q", o.conformanceProfile)
+		}
+		if focus == "" {
+			focus = profile.focus
+		}
+		if skip == "" {
+			skip = profile.skip
+		}
+	}
+
+	reportDir, err := ioutil.TempDir("", "conformance-junit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(reportDir)
+
+	// runErr is intentionally not returned immediately: e2e.test/ginkgo
+	// exit non-zero whenever any spec fails, which is the normal case
+	// --conformance-retry-failed exists to handle. Only decide the final
+	// outcome once the retry loop below has had a chance to clear failures.
+	runErr := xmlWrapSuite("conformance", "run", func() error {
+		return runGinkgo(focus, skip, o.conformanceParallel, o.conformanceDryRun, reportDir)
+	})
+
+	failed, ranAny, err := mergeGinkgoJUnit(reportDir, "conformance")
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= o.conformanceRetryFailed && len(failed) > 0; attempt++ {
+		retryDir, err := ioutil.TempDir("", "conformance-retry")
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("retry-%d", attempt)
+		runErr = xmlWrapSuite("conformance", name, func() error {
+			return runGinkgoSpecs(failed, o.conformanceParallel, o.conformanceDryRun, retryDir)
+		})
+		var ran bool
+		failed, ran, err = mergeGinkgoJUnit(retryDir, "conformance")
+		os.RemoveAll(retryDir)
+		if err != nil {
+			return err
+		}
+		ranAny = ranAny || ran
+	}
+
+	// If not a single spec ever produced JUnit output, the run itself
+	// failed to launch (missing binary, bad focus regex, crash before the
+	// first spec) rather than "zero specs failed" — report the launch
+	// error instead of silently returning a clean pass.
+	if !ranAny {
+		return runErr
+	}
+	if len(failed) > 0 {
+		return runErr
+	}
+	return nil
+}
+
+// runGinkgo shells out to e2e.test with the structured conformance flags.
+func runGinkgo(focus, skip string, parallel int, dryRun bool, reportDir string) error {
+	args := []string{
+		fmt.Sprintf("-ginkgo.focus=%s", focus),
+		fmt.Sprintf("-ginkgo.skip=%s", skip),
+		fmt.Sprintf("-report-dir=%s", reportDir),
+	}
+	if dryRun {
+		args = append(args, "-ginkgo.dryRun=true")
+	}
+	if parallel > 1 {
+		return finishRunning(exec.Command("ginkgo", append([]string{fmt.Sprintf("-nodes=%d", parallel), "e2e.test", "--"}, args...)...))
+	}
+	return finishRunning(exec.Command("e2e.test", args...))
+}
+
+// runGinkgoSpecs reruns only the named specs, used for --conformance-retry-failed.
+func runGinkgoSpecs(specs []string, parallel int, dryRun bool, reportDir string) error {
+	focus := ginkgoFocusForSpecs(specs)
+	return runGinkgo(focus, "", parallel, dryRun, reportDir)
+}
+
+// ginkgoFocusForSpecs builds a single -ginkgo.focus regex that matches the
+// given spec names literally. The regex is not anchored, so a spec whose
+// text contains one of the names also matches.
+func ginkgoFocusForSpecs(specs []string) string {
+	focus := ""
+	for i, s := range specs {
+		if i > 0 {
+			focus += "|"
+		}
+		focus += regexp.QuoteMeta(s)
+	}
+	return focus
+}
+
+// ginkgoJUnitSuite mirrors the subset of Ginkgo's per-spec JUnit schema
+// that mergeGinkgoJUnit needs to read back.
+type ginkgoJUnitSuite struct {
+	XMLName xml.Name `xml:"testsuite"`
+	Cases   []struct {
+		Name    string `xml:"name,attr"`
+		Failure *struct {
+			Message string `xml:",chardata"`
+		} `xml:"failure"`
+	} `xml:"testcase"`
+}
+
+// mergeGinkgoJUnit reads Ginkgo's per-spec JUnit output out of dir and
+// merges each spec into the top-level suite named suiteName as a single
+// authoritative testcase. Returns the names of specs that failed, and
+// whether any JUnit file was found at all — the latter distinguishes "the
+// run executed and 0 specs failed" from "the run never produced output"
+// (missing binary, bad focus regex, crash before the first spec).
+func mergeGinkgoJUnit(dir, suiteName string) (failed []string, ranAny bool, err error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "junit*.xml"))
+	if err != nil {
+		return nil, false, err
+	}
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, false, err
+		}
+		var parsed ginkgoJUnitSuite
+		if err := xml.Unmarshal(b, &parsed); err != nil {
+			return nil, false, err
+		}
+		ranAny = true
+		for _, c := range parsed.Cases {
+			name := c.Name
+			if c.Failure != nil {
+				failed = append(failed, name)
+			}
+			replaceGinkgoCase(suiteName, name, c.Failure)
+		}
+	}
+	return failed, ranAny, nil
+}
+
+// replaceGinkgoCase records (or re-records, on retry) a single spec's
+// outcome as the authoritative testcase for that spec name, so a spec
+// that passes on retry replaces its earlier failing entry instead of
+// appending a second one. Delegates the actual mutation to replaceCase,
+// which holds suitesMu for the duration.
+func replaceGinkgoCase(suiteName, specName string, failure *struct {
+	Message string `xml:",chardata"`
+}) {
+	c := testCase{ClassName: suiteName, Name: specName}
+	if failure != nil {
+		c.Failure = failure.Message
+	}
+	replaceCase(suiteName, c)
+	flushXML()
+}
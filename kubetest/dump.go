@@ -0,0 +1,222 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DumpCollector gathers one slice of post-mortem diagnostics (cluster
+// state, node logs, audit logs, metrics, ...) into dir. A collector that
+// fails does not abort the others; its error is recorded as a testcase.
+type DumpCollector interface {
+	Name() string
+	Collect(dir string) error
+}
+
+var dumpCollectors []DumpCollector
+
+// RegisterDumpCollector adds a collector to the set complete() runs
+// whenever --dump is set and either the run fails or, when --down is set,
+// the user hits ^C.
+func RegisterDumpCollector(c DumpCollector) {
+	dumpCollectors = append(dumpCollectors, c)
+}
+
+func init() {
+	RegisterDumpCollector(kubectlDumpCollector{})
+	RegisterDumpCollector(metricsDumpCollector{})
+}
+
+// runDumpCollectors fans out every registered collector (plus the
+// deployer's own node-log collector and, if configured, the audit-log
+// collector) into its own goroutine bounded by --dump-timeout, writing
+// into --dump/cluster-info. Each failure becomes a testcase
+// under the "dump" suite instead of aborting the rest of the dump.
+func runDumpCollectors(o *options, deploy deployer) {
+	dir := filepath.Join(o.dump, "cluster-info")
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		log.Printf("Failed to create dump dir %s: %v", dir, err)
+		return
+	}
+
+	collectors := append([]DumpCollector{}, dumpCollectors...)
+	if nc, ok := deploy.(NodeLogCollector); ok {
+		collectors = append(collectors, nodeLogDumpCollector{nc})
+	}
+	if o.auditLogPath != "" {
+		collectors = append(collectors, auditLogDumpCollector{o.auditLogPath})
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range collectors {
+		wg.Add(1)
+		go func(c DumpCollector) {
+			defer wg.Done()
+			runOneDumpCollector(c, dir, o.dumpTimeout)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// runOneDumpCollector runs a single collector with a timeout and records
+// its outcome as a testcase in the "dump" suite.
+func runOneDumpCollector(c DumpCollector, dir string, timeout time.Duration) {
+	xmlWrapSuite("dump", c.Name(), func() error {
+		done := make(chan error, 1)
+		go func() { done <- c.Collect(dir) }()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(timeout):
+			return fmt.Errorf("collector %s did not finish within %s", c.Name(), timeout)
+		}
+	})
+}
+
+// NodeLogCollector is implemented by deployers that know how to fetch
+// node-level logs for their own infrastructure (gce serial console, an
+// ssh tarball, docker logs, ...).
+type NodeLogCollector interface {
+	CollectNodeLogs(dir string) error
+}
+
+type nodeLogDumpCollector struct {
+	nc NodeLogCollector
+}
+
+func (nodeLogDumpCollector) Name() string { return "node-logs" }
+
+func (d nodeLogDumpCollector) Collect(dir string) error {
+	return d.nc.CollectNodeLogs(dir)
+}
+
+// kubectlDumpCollector gathers events, pod specs, node descriptions and
+// container logs across all namespaces via kubectl.
+type kubectlDumpCollector struct{}
+
+func (kubectlDumpCollector) Name() string { return "kubectl" }
+
+func (kubectlDumpCollector) Collect(dir string) error {
+	steps := []struct {
+		file string
+		args []string
+	}{
+		{"events.txt", []string{"get", "events", "--all-namespaces"}},
+		{"pods.yaml", []string{"get", "pods", "--all-namespaces", "-o", "yaml"}},
+		{"nodes.txt", []string{"describe", "nodes"}},
+	}
+	for _, s := range steps {
+		out, err := output(exec.Command("kubectl", s.args...))
+		if err != nil {
+			return fmt.Errorf("kubectl %v: %v", s.args, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, s.file), out, 0644); err != nil {
+			return err
+		}
+	}
+	return dumpContainerLogs(dir)
+}
+
+// dumpContainerLogs writes <dir>/<namespace>/<pod>/logs.txt, holding the
+// logs of all of the pod's containers, for every pod in every namespace.
+func dumpContainerLogs(dir string) error {
+	out, err := output(exec.Command("kubectl", "get", "pods", "--all-namespaces",
+		"-o", "jsonpath={range .items[*]}{.metadata.namespace}{\" \"}{.metadata.name}{\"\\n\"}{end}"))
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		ns, pod := fields[0], fields[1]
+		logs, err := output(exec.Command("kubectl", "logs", "-n", ns, pod, "--all-containers"))
+		if err != nil {
+			continue // best effort: a pod mid-restart shouldn't fail the whole dump
+		}
+		podDir := filepath.Join(dir, ns, pod)
+		if err := os.MkdirAll(podDir, 0775); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(podDir, "logs.txt"), logs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditLogDumpCollector snapshots the apiserver audit log when
+// --audit-log-path is set.
+type auditLogDumpCollector struct {
+	path string
+}
+
+func (auditLogDumpCollector) Name() string { return "audit-log" }
+
+func (d auditLogDumpCollector) Collect(dir string) error {
+	return finishRunning(exec.Command("cp", d.path, filepath.Join(dir, "audit.log")))
+}
+
+// metricsDumpCollector scrapes /metrics off the apiserver and every
+// kubelet and saves the raw Prometheus text. Goes through `kubectl get
+// --raw`, the same ambient kubeconfig auth the kubectlDumpCollector
+// already relies on, rather than an unauthenticated/untrusted-TLS HTTP
+// client that would fail against the self-signed apiserver cert.
+type metricsDumpCollector struct{}
+
+func (metricsDumpCollector) Name() string { return "metrics" }
+
+func (metricsDumpCollector) Collect(dir string) error {
+	var lastErr error
+
+	if out, err := output(exec.Command("kubectl", "get", "--raw", "/metrics")); err != nil {
+		lastErr = fmt.Errorf("apiserver metrics: %v", err)
+	} else if err := ioutil.WriteFile(filepath.Join(dir, "apiserver-metrics.txt"), out, 0644); err != nil {
+		return err
+	}
+
+	nodesOut, err := output(exec.Command("kubectl", "get", "nodes",
+		"-o", "jsonpath={range .items[*]}{.metadata.name}{\"\\n\"}{end}"))
+	if err != nil {
+		return fmt.Errorf("listing nodes for kubelet metrics: %v", err)
+	}
+	for _, node := range strings.Split(strings.TrimSpace(string(nodesOut)), "\n") {
+		if node == "" {
+			continue
+		}
+		raw := fmt.Sprintf("/api/v1/nodes/%s/proxy/metrics", node)
+		out, err := output(exec.Command("kubectl", "get", "--raw", raw))
+		if err != nil {
+			lastErr = fmt.Errorf("kubelet metrics for %s: %v", node, err)
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("kubelet-%s-metrics.txt", node)), out, 0644); err != nil {
+			return err
+		}
+	}
+	return lastErr
+}
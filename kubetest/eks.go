@@ -0,0 +1,96 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var (
+	eksClusterName = flag.String("eks-cluster", "", "Name of the EKS cluster to create or reuse")
+	eksRegion      = flag.String("eks-region", "us-west-2", "AWS region to create the EKS cluster in")
+	eksNodeGroup   = flag.String("eks-node-group", "", "Name of the managed node group to create alongside the cluster")
+	eksNodeCount   = flag.Int("eks-node-count", 3, "Number of worker nodes in the managed node group")
+	eksKubeVersion = flag.String("eks-kubernetes-version", "", "Kubernetes version to request from the EKS control plane")
+)
+
+// eks deploys a cluster via `eksctl`, AWS's reference EKS provisioning CLI.
+type eks struct {
+	cluster    string
+	region     string
+	nodeGroup  string
+	nodeCount  int
+	k8sVersion string
+}
+
+// NewEKS validates eks-specific flags/credentials and returns a deployer
+// that drives `eksctl` against AWS.
+func NewEKS() (deployer, error) {
+	if *eksClusterName == "" {
+		return nil, fmt.Errorf("--eks-cluster must be set for --deployment=eks")
+	}
+	if os.Getenv("AWS_PROFILE") == "" && os.Getenv("AWS_ACCESS_KEY_ID") == "" {
+		return nil, fmt.Errorf("--deployment=eks requires AWS_PROFILE or AWS_ACCESS_KEY_ID to be set")
+	}
+	return &eks{
+		cluster:    *eksClusterName,
+		region:     *eksRegion,
+		nodeGroup:  *eksNodeGroup,
+		nodeCount:  *eksNodeCount,
+		k8sVersion: *eksKubeVersion,
+	}, nil
+}
+
+func (e *eks) Up() error {
+	args := []string{"create", "cluster", "--name", e.cluster, "--region", e.region}
+	if e.k8sVersion != "" {
+		args = append(args, "--version", e.k8sVersion)
+	}
+	if e.nodeGroup != "" {
+		args = append(args, "--nodegroup-name", e.nodeGroup, "--nodes", fmt.Sprintf("%d", e.nodeCount))
+	}
+	return finishRunning(exec.Command("eksctl", args...))
+}
+
+func (e *eks) IsUp() error {
+	return finishRunning(exec.Command("eksctl", "get", "cluster", "--name", e.cluster, "--region", e.region))
+}
+
+func (e *eks) SetupKubecfg() error {
+	return finishRunning(exec.Command("eksctl", "utils", "write-kubeconfig", "--cluster", e.cluster, "--region", e.region))
+}
+
+func (e *eks) Down() error {
+	return finishRunning(exec.Command("eksctl", "delete", "cluster", "--name", e.cluster, "--region", e.region))
+}
+
+// Metadata satisfies DeployerMetadataProvider, reporting the EKS
+// region/node-count/cluster this run requested from eksctl.
+func (e *eks) Metadata() (map[string]interface{}, error) {
+	m := map[string]interface{}{
+		"cloud-provider": "aws",
+		"region":         e.region,
+		"cluster":        e.cluster,
+	}
+	if e.nodeGroup != "" {
+		m["node-count"] = e.nodeCount
+	}
+	return m, nil
+}
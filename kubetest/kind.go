@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	kindClusterName = flag.String("kind-cluster", "kind", "Name of the kind cluster to create or reuse")
+	kindConfigPath  = flag.String("kind-config", "", "Path to a kind cluster configuration file")
+	kindNodeImage   = flag.String("kind-node-image", "", "Docker image to use for kind's nodes, defaults to kind's own default")
+)
+
+// kind deploys a cluster of docker-hosted "nodes" via the `kind` CLI.
+type kind struct {
+	cluster   string
+	config    string
+	nodeImage string
+}
+
+// NewKind returns a deployer that drives the local kind binary, which in
+// turn requires a running docker daemon.
+func NewKind() (deployer, error) {
+	return &kind{
+		cluster:   *kindClusterName,
+		config:    *kindConfigPath,
+		nodeImage: *kindNodeImage,
+	}, nil
+}
+
+func (k *kind) Up() error {
+	args := []string{"create", "cluster", "--name", k.cluster}
+	if k.config != "" {
+		args = append(args, "--config", k.config)
+	}
+	if k.nodeImage != "" {
+		args = append(args, "--image", k.nodeImage)
+	}
+	return finishRunning(exec.Command("kind", args...))
+}
+
+func (k *kind) IsUp() error {
+	out, err := output(exec.Command("kind", "get", "clusters"))
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), k.cluster) {
+		return fmt.Errorf("cluster %q not found among kind clusters", k.cluster)
+	}
+	return nil
+}
+
+func (k *kind) SetupKubecfg() error {
+	return finishRunning(exec.Command("kind", "export", "kubeconfig", "--name", k.cluster))
+}
+
+func (k *kind) Down() error {
+	return finishRunning(exec.Command("kind", "delete", "cluster", "--name", k.cluster))
+}
+
+// Metadata satisfies DeployerMetadataProvider, reporting the kind cluster
+// name, config file and node image this run started with.
+func (k *kind) Metadata() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"cloud-provider": "kind",
+		"cluster":        k.cluster,
+		"config":         k.config,
+		"node-image":     k.nodeImage,
+	}, nil
+}
+
+// CollectNodeLogs satisfies NodeLogCollector by pulling `docker logs` from
+// each kind node container, since kind's "nodes" are just docker containers.
+func (k *kind) CollectNodeLogs(dir string) error {
+	out, err := output(exec.Command("kind", "get", "nodes", "--name", k.cluster))
+	if err != nil {
+		return err
+	}
+	for _, node := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if node == "" {
+			continue
+		}
+		logs, err := output(exec.Command("docker", "logs", node))
+		if err != nil {
+			continue // best effort: a node that already exited shouldn't fail the dump
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, node+".log"), logs, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
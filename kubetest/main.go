@@ -17,7 +17,6 @@ limitations under the License.
 package main
 
 import (
-	"encoding/json"
 	"encoding/xml"
 	"flag"
 	"fmt"
@@ -27,8 +26,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -52,24 +51,36 @@ func initPath(path string) string {
 }
 
 type options struct {
-	build       buildStrategy
-	charts      bool
-	checkLeaks  bool
-	checkSkew   bool
-	deployment  string
-	down        bool
-	dump        string
-	extract     extractStrategies
-	federation  bool
-	kubemark    bool
-	publish     string
-	save        string
-	skew        bool
-	stage       stageStrategy
-	testArgs    string
-	test        bool
-	up          bool
-	upgradeArgs string
+	auditLogPath           string
+	build                  buildStrategy
+	charts                 bool
+	checkLeaks             bool
+	checkSkew              bool
+	ciBucket               string
+	conformance            bool
+	conformanceDryRun      bool
+	conformanceFocus       string
+	conformanceParallel    int
+	conformanceProfile     string
+	conformanceRetryFailed int
+	conformanceSkip        string
+	deployment             string
+	down                   bool
+	dump                   string
+	dumpTimeout            time.Duration
+	extract                extractStrategies
+	federation             bool
+	kubemark               bool
+	publish                string
+	save                   string
+	skew                   bool
+	stage                  stageStrategy
+	testArgs               string
+	test                   bool
+	up                     bool
+	upgradeArgs            string
+	upgradeFrom            string
+	upgradeTo              string
 }
 
 func defineFlags() *options {
@@ -78,9 +89,19 @@ func defineFlags() *options {
 	flag.BoolVar(&o.charts, "charts", false, "If true, run charts tests")
 	flag.BoolVar(&o.checkSkew, "check-version-skew", true, "Verify client and server versions match")
 	flag.BoolVar(&o.checkLeaks, "check-leaked-resources", false, "Ensure project ends with the same resources")
-	flag.StringVar(&o.deployment, "deployment", "bash", "Choices: none/bash/kops/kubernetes-anywhere")
+	flag.StringVar(&o.ciBucket, "ci-bucket", "gs://kubernetes-release-dev", "GCS bucket to resolve ci/<job>/<build> extract modes against")
+	flag.BoolVar(&o.conformance, "conformance", false, "If true, run e2e.test directly in conformance mode instead of via --test_args")
+	flag.StringVar(&o.conformanceFocus, "conformance-focus", "", "Regexp passed to e2e.test -ginkgo.focus")
+	flag.StringVar(&o.conformanceSkip, "conformance-skip", "", "Regexp passed to e2e.test -ginkgo.skip")
+	flag.IntVar(&o.conformanceParallel, "conformance-parallel", 1, "Number of parallel Ginkgo nodes to run e2e.test with")
+	flag.BoolVar(&o.conformanceDryRun, "conformance-dry-run", false, "If true, pass -ginkgo.dryRun to e2e.test instead of actually running specs")
+	flag.IntVar(&o.conformanceRetryFailed, "conformance-retry-failed", 0, "Number of times to rerun only the specs that failed, merging results into a single JUnit case per spec")
+	flag.StringVar(&o.conformanceProfile, "conformance-profile", "", "Choices: fast/full/network/storage. Expands to a well-known --conformance-focus/--conformance-skip pair")
+	flag.StringVar(&o.deployment, "deployment", "bash", "Choices: none/bash/kops/kubernetes-anywhere/eks/aks/minikube/kind/terraform")
 	flag.BoolVar(&o.down, "down", false, "If true, tear down the cluster before exiting.")
 	flag.StringVar(&o.dump, "dump", "", "If set, dump cluster logs to this location on test or cluster-up failure")
+	flag.DurationVar(&o.dumpTimeout, "dump-timeout", 5*time.Minute, "Max time to spend on each dump collector")
+	flag.StringVar(&o.auditLogPath, "audit-log-path", "", "If set, collect an apiserver audit-log snapshot from this path into --dump on failure")
 	flag.Var(&o.extract, "extract", "Extract k8s binaries from the specified release location")
 	flag.BoolVar(&o.federation, "federation", false, "If true, start/tear down the federation control plane along with the clusters. To only start/tear down the federation control plane, specify --deploy=none")
 	flag.BoolVar(&o.kubemark, "kubemark", false, "If true, run kubemark tests.")
@@ -93,28 +114,144 @@ func defineFlags() *options {
 	flag.DurationVar(&timeout, "timeout", time.Duration(0), "Terminate testing after the timeout duration (s/m/h)")
 	flag.BoolVar(&o.up, "up", false, "If true, start the the e2e cluster. If cluster is already up, recreate it.")
 	flag.StringVar(&o.upgradeArgs, "upgrade_args", "", "If set, run upgrade tests before other tests")
+	flag.StringVar(&o.upgradeFrom, "upgrade-from", "", "Version or extract location to bring the cluster up at before upgrading, e.g. ci/latest-1.29")
+	flag.StringVar(&o.upgradeTo, "upgrade-to", "", "Version or extract location to upgrade the cluster to, e.g. ci/latest")
 
 	flag.BoolVar(&verbose, "v", false, "If true, print all command output.")
 	return &o
 }
 
+// property is a single name/value pair under a <properties> block, used to
+// record facts about the run (git SHA, deployer, k8s version) that aren't
+// tied to any one test case.
+type property struct {
+	XMLName xml.Name `xml:"property"`
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
+}
+
 type testCase struct {
 	XMLName   xml.Name `xml:"testcase"`
 	ClassName string   `xml:"classname,attr"`
 	Name      string   `xml:"name,attr"`
 	Time      float64  `xml:"time,attr"`
+	Timestamp string   `xml:"timestamp,attr"`
 	Failure   string   `xml:"failure,omitempty"`
 }
 
 type TestSuite struct {
-	XMLName  xml.Name `xml:"testsuite"`
-	Failures int      `xml:"failures,attr"`
-	Tests    int      `xml:"tests,attr"`
-	Time     float64  `xml:"time,attr"`
-	Cases    []testCase
+	XMLName    xml.Name   `xml:"testsuite"`
+	Name       string     `xml:"name,attr"`
+	Failures   int        `xml:"failures,attr"`
+	Tests      int        `xml:"tests,attr"`
+	Time       float64    `xml:"time,attr"`
+	Timestamp  string     `xml:"timestamp,attr"`
+	Properties []property `xml:"properties>property,omitempty"`
+	Cases      []testCase `xml:"testcase"`
+}
+
+// testSuites is the <testsuites> root that junit_runner.xml now emits,
+// grouping one TestSuite per suite name passed to xmlWrapSuite (the
+// default "kubetest" suite holding Build/Stage/Extract, pre-upgrade,
+// during-upgrade, post-upgrade, ...).
+type testSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []*TestSuite `xml:"testsuite"`
+}
+
+// defaultSuiteName groups the steps (Build/Stage/Extract/...) that aren't
+// part of a more specific named phase like an upgrade stage.
+const defaultSuiteName = "kubetest"
+
+var (
+	suites = &testSuites{}
+	// suitesMu guards every read/write of suites and the TestSuites/TestCase
+	// slices it owns. Dump collectors (chunk0-5) run one goroutine per
+	// collector and all of them call into xmlWrapSuite concurrently, so
+	// mutation of this shared state can't be lock-free.
+	suitesMu sync.Mutex
+)
+
+// suiteNamed looks up (or creates) the named suite. Callers must hold
+// suitesMu.
+func suiteNamed(name string) *TestSuite {
+	for _, s := range suites.Suites {
+		if s.Name == name {
+			return s
+		}
+	}
+	s := &TestSuite{Name: name, Timestamp: time.Now().Format(time.RFC3339)}
+	suites.Suites = append(suites.Suites, s)
+	return s
+}
+
+// xmlWrap invokes f, recording its outcome as a testCase named `name` in
+// the default suite. This is the common path used by the build/stage/
+// extract/publish steps.
+func xmlWrap(name string, f func() error) error {
+	return xmlWrapSuite(defaultSuiteName, name, f)
+}
+
+// xmlWrapSuite invokes f, recording its outcome as a testCase named `name`
+// under the testsuite `suiteName` (creating it on first use). Every
+// completed case is immediately flushed to junit_runner.xml (when a dump
+// path has been configured) so a SIGKILL mid-run still leaves partial,
+// useful results on disk instead of nothing. Safe to call concurrently.
+func xmlWrapSuite(suiteName, name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	c := testCase{
+		ClassName: suiteName,
+		Name:      name,
+		Time:      time.Since(start).Seconds(),
+		Timestamp: start.Format(time.RFC3339),
+	}
+	if err != nil {
+		c.Failure = err.Error()
+	}
+	addCase(suiteName, c)
+	flushXML()
+	return err
 }
 
-var suite TestSuite
+// addCase appends c to suiteName's case list (creating the suite on first
+// use), bumping its Tests/Failures counters. Safe to call concurrently.
+func addCase(suiteName string, c testCase) {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	s := suiteNamed(suiteName)
+	if c.Failure != "" {
+		s.Failures++
+	}
+	s.Cases = append(s.Cases, c)
+	s.Tests++
+}
+
+// replaceCase swaps out any existing case named c.Name in suiteName for c,
+// adjusting counters accordingly. Used by the conformance retry loop
+// (chunk0-4), where a spec that passes on retry must overwrite its earlier
+// failing entry rather than appending a second one. Safe to call
+// concurrently.
+func replaceCase(suiteName string, c testCase) {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	s := suiteNamed(suiteName)
+	for i, existing := range s.Cases {
+		if existing.Name == c.Name {
+			if existing.Failure != "" {
+				s.Failures--
+			}
+			s.Tests--
+			s.Cases = append(s.Cases[:i], s.Cases[i+1:]...)
+			break
+		}
+	}
+	if c.Failure != "" {
+		s.Failures++
+	}
+	s.Cases = append(s.Cases, c)
+	s.Tests++
+}
 
 func validWorkingDirectory() error {
 	cwd, err := os.Getwd()
@@ -132,9 +269,58 @@ func validWorkingDirectory() error {
 	return nil
 }
 
+// dumpPath and runStart let xmlWrapSuite stream partial results to disk as
+// soon as each case completes, set once by complete() before any steps run.
+var (
+	dumpPath string
+	runStart time.Time
+)
+
+// annotateSuiteProperties records run-level facts (git SHA, deployer,
+// resolved k8s version) as <properties> on the default suite, so they show
+// up once per junit_runner.xml rather than being repeated per testcase.
+func annotateSuiteProperties(deployment string) {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	s := suiteNamed(defaultSuiteName)
+	s.Properties = []property{
+		{Name: "deployer", Value: deployment},
+		{Name: "k8s-version", Value: findVersion()},
+		{Name: "git-sha", Value: gitSHA()},
+	}
+}
+
+// gitSHA returns the HEAD commit of the working directory, or "" if it
+// can't be determined (e.g. not a git checkout).
+func gitSHA() string {
+	out, err := output(exec.Command("git", "rev-parse", "HEAD"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// flushXML rewrites junit_runner.xml from the current in-memory suites, if
+// a dump path has been configured. Each xmlWrapSuite case calls this so
+// that a SIGKILL mid-run leaves the results gathered so far on disk.
+func flushXML() {
+	if dumpPath == "" {
+		return
+	}
+	writeXML(dumpPath, runStart)
+}
+
+// writeXML marshals the current suites to junit_runner.xml. Holds suitesMu
+// for the whole marshal-and-write so concurrent dump collectors (chunk0-5)
+// can't tear a partially-written file or race on suites.Suites.
 func writeXML(dump string, start time.Time) {
-	suite.Time = time.Since(start).Seconds()
-	out, err := xml.MarshalIndent(&suite, "", "    ")
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+
+	for _, s := range suites.Suites {
+		s.Time = time.Since(start).Seconds()
+	}
+	out, err := xml.MarshalIndent(suites, "", "    ")
 	if err != nil {
 		log.Fatalf("Could not marshal XML: %s", err)
 	}
@@ -160,19 +346,38 @@ type deployer interface {
 	Down() error
 }
 
+// deployerFactories holds every deployment strategy registered via
+// RegisterDeployer, keyed by the --deployment name that selects it.
+var deployerFactories = map[string]func() (deployer, error){}
+
+// RegisterDeployer makes a deployer available as a --deployment choice.
+// Out-of-tree deployers (or deployers that don't want to live in this
+// package) can call this from an init() function.
+func RegisterDeployer(name string, factory func() (deployer, error)) {
+	if _, dup := deployerFactories[name]; dup {
+		log.Fatalf("RegisterDeployer called twice for deployment %q", name)
+	}
+	deployerFactories[name] = factory
+}
+
+func init() {
+	RegisterDeployer("bash", func() (deployer, error) { return bash{}, nil })
+	RegisterDeployer("kops", NewKops)
+	RegisterDeployer("kubernetes-anywhere", NewKubernetesAnywhere)
+	RegisterDeployer("none", func() (deployer, error) { return noneDeploy{}, nil })
+	RegisterDeployer("eks", NewEKS)
+	RegisterDeployer("aks", NewAKS)
+	RegisterDeployer("minikube", NewMinikube)
+	RegisterDeployer("kind", NewKind)
+	RegisterDeployer("terraform", NewTerraform)
+}
+
 func getDeployer(deployment string) (deployer, error) {
-	switch deployment {
-	case "bash":
-		return bash{}, nil
-	case "kops":
-		return NewKops()
-	case "kubernetes-anywhere":
-		return NewKubernetesAnywhere()
-	case "none":
-		return noneDeploy{}, nil
-	default:
+	factory, ok := deployerFactories[deployment]
+	if !ok {
 		return nil, fmt.Errorf("Unknown deployment strategy %q", deployment)
 	}
+	return factory()
 }
 
 func main() {
@@ -202,11 +407,14 @@ func complete(o *options) error {
 		interrupt.Reset(timeout)
 	}
 
+	runStart = time.Now()
 	if o.dump != "" {
+		dumpPath = o.dump
 		defer writeMetadata(o.dump)
-		defer writeXML(o.dump, time.Now())
+		defer writeXML(o.dump, runStart)
 	}
-	if err := prepare(); err != nil {
+	annotateSuiteProperties(o.deployment)
+	if err := prepare(o.deployment); err != nil {
 		return fmt.Errorf("failed to prepare test environment: %v", err)
 	}
 	if err := acquireKubernetes(o); err != nil {
@@ -221,6 +429,8 @@ func complete(o *options) error {
 	if err != nil {
 		return fmt.Errorf("error creating deployer: %v", err)
 	}
+	activeDeployer = deploy
+	activeDeployment = o.deployment
 
 	if o.down {
 		// listen for signals such as ^C and gracefully attempt to clean up
@@ -229,6 +439,9 @@ func complete(o *options) error {
 		go func() {
 			for range c {
 				log.Print("Captured ^C, gracefully attempting to cleanup resources..")
+				if o.dump != "" {
+					runDumpCollectors(o, deploy)
+				}
 				var fedErr, err error
 				if o.federation {
 					if fedErr = FedDown(); fedErr != nil {
@@ -245,11 +458,23 @@ func complete(o *options) error {
 		}()
 	}
 
-	log.Printf("before run(deploy, *o)")
-	if err := run(deploy, *o); err != nil {
-		return err
+	var runErr error
+	switch {
+	case o.upgradeFrom != "" && o.upgradeTo != "":
+		runErr = upgradeWorkflow(deploy, o)
+	case o.conformance:
+		runErr = runConformance(deploy, o)
+	default:
+		log.Printf("before run(deploy, *o)")
+		runErr = run(deploy, *o)
+		log.Printf("after run(deploy, *o)")
+	}
+	if runErr != nil {
+		if o.dump != "" {
+			runDumpCollectors(o, deploy)
+		}
+		return runErr
 	}
-	log.Printf("after run(deploy, *o)")
 
 
 	// Save the state if we upped a new cluster without downing it
@@ -271,6 +496,20 @@ func complete(o *options) error {
 }
 
 func acquireKubernetes(o *options) error {
+	// An --upgrade-from/--upgrade-to pair drives its own extraction: start
+	// the cluster at upgrade-from, the upgrade workflow pulls upgrade-to
+	// later once the cluster is up.
+	if o.upgradeFrom != "" && o.upgradeTo != "" {
+		return xmlWrap("Extract (upgrade-from)", func() error {
+			strategies, err := resolveCIExtract(o.upgradeFrom, o.ciBucket)
+			if err != nil {
+				return err
+			}
+			o.extract = strategies
+			return o.extract.Extract()
+		})
+	}
+
 	// Potentially build kubernetes
 	if o.build.Enabled() {
 		if err := xmlWrap("Build", o.build.Build); err != nil {
@@ -338,31 +577,6 @@ func findVersion() string {
 	return "unknown" // Sad trombone
 }
 
-// Write metadata.json, including version and env arg data.
-func writeMetadata(path string) error {
-	m := make(map[string]string)
-	ver := findVersion()
-	m["version"] = ver // TODO(fejta): retire
-	m["job-version"] = ver
-	re := regexp.MustCompile(`^BUILD_METADATA_(.+)$`)
-	for _, e := range os.Environ() {
-		p := strings.SplitN(e, "=", 2)
-		r := re.FindStringSubmatch(p[0])
-		if r == nil {
-			continue
-		}
-		k, v := strings.ToLower(r[1]), p[1]
-		m[k] = v
-	}
-	f, err := os.Create(filepath.Join(path, "metadata.json"))
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	e := json.NewEncoder(f)
-	return e.Encode(m)
-}
-
 // Install cloudsdk tarball to location, updating PATH
 func installGcloud(tarball string, location string) error {
 
@@ -484,7 +698,7 @@ func chmodArtifacts() error {
 	return finishRunning(exec.Command("chmod", "-R", "o+r", artifacts))
 }
 
-func prepare() error {
+func prepare(deployment string) error {
 	kp := os.Getenv("KUBERNETES_PROVIDER")
 	switch kp {
 	case "gce", "gke", "kubemark":
@@ -497,6 +711,22 @@ func prepare() error {
 		}
 	}
 
+	// Validate deployer-specific credentials before Up() is attempted.
+	switch deployment {
+	case "eks":
+		if err := prepareAws(); err != nil {
+			return err
+		}
+	case "aks":
+		if os.Getenv("AZURE_CREDENTIALS") == "" {
+			return fmt.Errorf("--deployment=aks requires setting AZURE_CREDENTIALS")
+		}
+	case "minikube", "kind":
+		if err := finishRunning(exec.Command("docker", "info")); err != nil {
+			return fmt.Errorf("--deployment=%s requires a reachable docker daemon: %v", deployment, err)
+		}
+	}
+
 	if err := activateServiceAccount(); err != nil {
 		return err
 	}
@@ -0,0 +1,151 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// metadataSchemaVersion identifies the shape of metadata-v2.json so
+// downstream dashboards can evolve alongside it without breaking on older
+// kubetest binaries.
+const metadataSchemaVersion = "2"
+
+// activeDeployer and activeDeployment are set by complete() once the
+// deployer is constructed, so writeMetadata (invoked via defer before the
+// deployer exists) can still reach them once the run actually gets there.
+var (
+	activeDeployer   deployer
+	activeDeployment string
+)
+
+// DeployerMetadataProvider is implemented by deployers that know extra
+// facts about the infrastructure they stood up (node counts, region,
+// instance types, cloud provider, cluster UUID, ...).
+type DeployerMetadataProvider interface {
+	Metadata() (map[string]interface{}, error)
+}
+
+// metadataProviders holds fields registered via RegisterMetadataProvider,
+// keyed by provider name. Out-of-tree code (e.g. a federation plugin) can
+// contribute metadata-v2.json fields without patching this file.
+var metadataProviders = map[string]func() (map[string]interface{}, error){}
+
+// RegisterMetadataProvider adds a named contributor to metadata-v2.json's
+// "infra" section.
+func RegisterMetadataProvider(name string, fn func() (map[string]interface{}, error)) {
+	if _, dup := metadataProviders[name]; dup {
+		log.Fatalf("RegisterMetadataProvider called twice for %q", name)
+	}
+	metadataProviders[name] = fn
+}
+
+// metadataV2 is the nested metadata-v2.json schema: build provenance,
+// infra facts from the deployer and registered providers, and timing.
+type metadataV2 struct {
+	SchemaVersion string                 `json:"schema_version"`
+	Build         map[string]interface{} `json:"build"`
+	Infra         map[string]interface{} `json:"infra"`
+	Test          map[string]interface{} `json:"test"`
+	Timing        map[string]interface{} `json:"timing"`
+}
+
+// Write metadata.json, including version and env arg data, plus a richer
+// metadata-v2.json with deployer- and provider-contributed fields.
+func writeMetadata(path string) error {
+	flat := make(map[string]string)
+	ver := findVersion()
+	flat["version"] = ver // TODO(fejta): retire
+	flat["job-version"] = ver
+	re := regexp.MustCompile(`^BUILD_METADATA_(.+)$`)
+	for _, e := range os.Environ() {
+		p := strings.SplitN(e, "=", 2)
+		r := re.FindStringSubmatch(p[0])
+		if r == nil {
+			continue
+		}
+		k, v := strings.ToLower(r[1]), p[1]
+		flat[k] = v
+	}
+	if err := writeJSON(filepath.Join(path, "metadata.json"), flat); err != nil {
+		return err
+	}
+
+	infra := make(map[string]interface{})
+	if provider, ok := activeDeployer.(DeployerMetadataProvider); ok {
+		fields, err := provider.Metadata()
+		if err != nil {
+			log.Printf("Failed to collect deployer metadata: %v", err)
+		} else {
+			for k, v := range fields {
+				infra[k] = v
+			}
+		}
+	}
+	for name, fn := range metadataProviders {
+		fields, err := fn()
+		if err != nil {
+			log.Printf("Metadata provider %s failed: %v", name, err)
+			continue
+		}
+		for k, v := range fields {
+			infra[k] = v
+		}
+	}
+
+	v2 := metadataV2{
+		SchemaVersion: metadataSchemaVersion,
+		Build: map[string]interface{}{
+			"version": ver,
+			"git-sha": gitSHA(),
+		},
+		Infra: infra,
+		Test: map[string]interface{}{
+			"deployment": activeDeployment,
+		},
+		Timing: map[string]interface{}{
+			"elapsed-seconds": timeSinceRunStart(),
+		},
+	}
+	return writeJSON(filepath.Join(path, "metadata-v2.json"), v2)
+}
+
+// timeSinceRunStart reports how long complete() has been running, or 0 if
+// runStart hasn't been set yet.
+func timeSinceRunStart() float64 {
+	if runStart.IsZero() {
+		return 0
+	}
+	return time.Since(runStart).Seconds()
+}
+
+func writeJSON(path string, v interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os/exec"
+)
+
+var (
+	minikubeDriver     = flag.String("minikube-driver", "docker", "Driver for minikube to use (docker, kvm2, virtualbox, ...)")
+	minikubeKubeVersion = flag.String("minikube-kubernetes-version", "", "Kubernetes version for minikube to start, defaults to minikube's own default")
+	minikubeProfile    = flag.String("minikube-profile", "minikube", "Name of the minikube profile to start/stop")
+)
+
+// minikube deploys a local, single-node cluster via the `minikube` CLI.
+type minikube struct {
+	driver     string
+	k8sVersion string
+	profile    string
+}
+
+// NewMinikube returns a deployer that drives the local minikube binary.
+func NewMinikube() (deployer, error) {
+	return &minikube{
+		driver:     *minikubeDriver,
+		k8sVersion: *minikubeKubeVersion,
+		profile:    *minikubeProfile,
+	}, nil
+}
+
+func (m *minikube) Up() error {
+	args := []string{"start", "--profile", m.profile, "--driver", m.driver}
+	if m.k8sVersion != "" {
+		args = append(args, "--kubernetes-version", m.k8sVersion)
+	}
+	return finishRunning(exec.Command("minikube", args...))
+}
+
+func (m *minikube) IsUp() error {
+	return finishRunning(exec.Command("minikube", "status", "--profile", m.profile))
+}
+
+func (m *minikube) SetupKubecfg() error {
+	return finishRunning(exec.Command("minikube", "update-context", "--profile", m.profile))
+}
+
+func (m *minikube) Down() error {
+	return finishRunning(exec.Command("minikube", "delete", "--profile", m.profile))
+}
+
+// Metadata satisfies DeployerMetadataProvider, reporting the minikube
+// driver and profile this run started with.
+func (m *minikube) Metadata() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"cloud-provider": "minikube",
+		"driver":         m.driver,
+		"profile":        m.profile,
+	}, nil
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	terraformModule           = flag.String("terraform-module", "", "Path to the terraform module to apply for --deployment=terraform")
+	terraformVarsFile         = flag.String("terraform-vars-file", "", "Path to a terraform .tfvars file to pass via -var-file")
+	terraformKubeconfigOutput = flag.String("terraform-kubeconfig-output", "kubeconfig", "Name of the terraform output holding a raw kubeconfig")
+)
+
+// terraform deploys a cluster by applying a user-supplied module, leaving
+// the actual infrastructure recipe (and its kubeconfig-producing output)
+// up to the caller instead of a purpose-built Go deployer. TF_VAR_* env
+// vars reach `terraform` the normal way; no special handling is needed.
+type terraform struct {
+	module           string
+	varsFile         string
+	kubeconfigOutput string
+	statePath        string
+}
+
+// NewTerraform validates --terraform-module and returns a deployer that
+// drives `terraform init/apply/destroy` against it.
+func NewTerraform() (deployer, error) {
+	if *terraformModule == "" {
+		return nil, fmt.Errorf("--terraform-module must be set for --deployment=terraform")
+	}
+	return &terraform{
+		module:           *terraformModule,
+		varsFile:         *terraformVarsFile,
+		kubeconfigOutput: *terraformKubeconfigOutput,
+		statePath:        filepath.Join(artifacts, "terraform.tfstate"),
+	}, nil
+}
+
+func (t *terraform) varArgs() []string {
+	if t.varsFile == "" {
+		return nil
+	}
+	return []string{"-var-file=" + t.varsFile}
+}
+
+// command builds a terraform subcommand rooted at t.module: the 0.12+ CLI
+// dropped the trailing directory argument to apply/destroy/plan (and the
+// -state/-state-out/-backup flags on them), so the module is now selected
+// via the working directory instead.
+func (t *terraform) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("terraform", args...)
+	cmd.Dir = t.module
+	return cmd
+}
+
+// init (re-)initializes the module against a local backend rooted at
+// t.statePath, the 0.12+ replacement for passing -state= to apply/destroy.
+func (t *terraform) init() error {
+	return finishRunning(t.command("init", "-input=false", "-backend-config=path="+t.statePath))
+}
+
+func (t *terraform) Up() error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	args := append([]string{"apply", "-auto-approve"}, t.varArgs()...)
+	if err := finishRunning(t.command(args...)); err != nil {
+		return err
+	}
+	return t.SetupKubecfg()
+}
+
+func (t *terraform) IsUp() error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	out, err := output(t.command("show"))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return fmt.Errorf("terraform state at %s describes no resources", t.statePath)
+	}
+	return nil
+}
+
+func (t *terraform) SetupKubecfg() error {
+	out, err := output(t.command("output", "-raw", t.kubeconfigOutput))
+	if err != nil {
+		return fmt.Errorf("failed to read terraform output %q: %v", t.kubeconfigOutput, err)
+	}
+	return ioutil.WriteFile(home(".kube", "config"), out, 0600)
+}
+
+func (t *terraform) Down() error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	args := append([]string{"destroy", "-auto-approve"}, t.varArgs()...)
+	return finishRunning(t.command(args...))
+}
+
+// Metadata satisfies DeployerMetadataProvider, reporting which module and
+// kubeconfig output this run applied — the actual infra shape is up to
+// whatever that module declares, so it isn't reported here.
+func (t *terraform) Metadata() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"cloud-provider":    "terraform",
+		"module":            t.module,
+		"kubeconfig-output": t.kubeconfigOutput,
+	}, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// ciAliasRE matches the version aliases kubetest understands under the
+// ci/ extract mode, e.g. "ci/latest" or "ci/latest-1.29".
+var ciAliasRE = regexp.MustCompile(`^ci/(latest(?:-[0-9]+\.[0-9]+)?)$`)
+
+// resolveCIExtract turns a "ci/<job>/<build>" (or "ci/latest[-x.y]") extract
+// location into the extractStrategies that --extract already knows how to
+// consume, resolving version aliases against bucket (the --ci-bucket value,
+// gs://kubernetes-release-dev by default).
+func resolveCIExtract(location, bucket string) (extractStrategies, error) {
+	if !strings.HasPrefix(location, "ci/") {
+		var e extractStrategies
+		if err := e.Set(location); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+
+	rest := strings.TrimPrefix(location, "ci/")
+	if m := ciAliasRE.FindStringSubmatch(location); m != nil {
+		marker := fmt.Sprintf("%s/%s.txt", bucket, m[1])
+		out, err := output(exec.Command("gsutil", "cat", marker))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s via %s: %v", location, marker, err)
+		}
+		rest = strings.TrimSpace(string(out))
+	}
+
+	var e extractStrategies
+	if err := e.Set(fmt.Sprintf("%s/%s", bucket, rest)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// upgradeWorkflow runs a from/to cluster upgrade: stand the cluster up at
+// upgradeFrom, exercise a baseline conformance pass, upgrade the control
+// plane and then the nodes to upgradeTo, and re-run the tests, recording
+// pre-upgrade, during-upgrade and post-upgrade results as distinct
+// testsuite entries.
+func upgradeWorkflow(deploy deployer, o *options) error {
+	toExtract, err := resolveCIExtract(o.upgradeTo, o.ciBucket)
+	if err != nil {
+		return fmt.Errorf("failed to resolve --upgrade-to=%s: %v", o.upgradeTo, err)
+	}
+
+	if err := xmlWrapSuite("pre-upgrade", "conformance", func() error {
+		if err := deploy.Up(); err != nil {
+			return err
+		}
+		return run(deploy, *o)
+	}); err != nil {
+		return err
+	}
+
+	if err := xmlWrapSuite("during-upgrade", "extract", toExtract.Extract); err != nil {
+		return err
+	}
+	if err := xmlWrapSuite("during-upgrade", "control-plane", func() error { return upgradeControlPlane(o) }); err != nil {
+		return err
+	}
+	if err := xmlWrapSuite("during-upgrade", "nodes", func() error { return upgradeNodes(o) }); err != nil {
+		return err
+	}
+
+	return xmlWrapSuite("post-upgrade", "conformance", func() error {
+		return run(deploy, *o)
+	})
+}
+
+// upgradeControlPlane swaps the control plane binaries in before the nodes,
+// mirroring how kube-up.sh/e2e-node-upgrade.sh stage an N-1->N upgrade.
+func upgradeControlPlane(o *options) error {
+	return finishRunning(exec.Command("bash", "-c", "hack/e2e-internal/e2e-upgrade.sh control-plane"))
+}
+
+// upgradeNodes swaps the node binaries in after the control plane has
+// already been upgraded.
+func upgradeNodes(o *options) error {
+	return finishRunning(exec.Command("bash", "-c", "hack/e2e-internal/e2e-upgrade.sh nodes"))
+}